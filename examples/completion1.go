@@ -0,0 +1,22 @@
+// prints a completion script instead of parsing, e.g.
+//   go run completion1.go bash > _myprog
+package main
+
+import (
+    "fmt"
+    "os"
+    "github.com/stevedonovan/xflag"
+)
+
+func main() {
+    flags := xflag.NewFlag()
+    flags.String("name","dolly","name of object")
+    flags.OpenFile("input","stdin","input file")
+    flags.String("color","red","favourite color")
+    flags.CompleteWith("color","red","green","blue")
+    if len(os.Args) != 2 {
+        fmt.Fprintln(os.Stderr,"usage: completion1 bash|zsh|fish")
+        os.Exit(1)
+    }
+    flags.MustParse(flags.GenerateCompletion(os.Args[1],os.Stdout))
+}
@@ -0,0 +1,31 @@
+// read a config file in JSON or TOML instead of the plain <var>=<value> form
+package main
+
+import (
+    "fmt"
+    "io/ioutil"
+    "github.com/stevedonovan/xflag"
+)
+
+var config = `
+{
+    "name": "bonzo dog",
+    "age": 12,
+    "owners": ["Alice","John"]
+}
+`
+
+var (
+    flags = xflag.NewFlag()
+    name = flags.String("name","dolly","name of object")
+    age = flags.Int("age",40,"age of object")
+    owners = flags.StringList("owners","self","owners of this animal")
+)
+
+func main() {
+    tmp,_ := ioutil.TempFile("","tmp")
+    tmp.WriteString(config)
+    tmp.Close()
+    flags.ParseConfigFormat(tmp.Name(),xflag.JSON)
+    fmt.Printf("name %q %d owners: %v\n",*name,*age,*owners)
+}
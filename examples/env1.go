@@ -0,0 +1,16 @@
+// flags left unset on the command line fall back to environment variables
+package main
+
+import (
+    "fmt"
+    "github.com/stevedonovan/xflag"
+)
+
+func main() {
+    flags := xflag.NewFlag()
+    host := flags.String("host","localhost","server host")
+    port := flags.Int("port",8080,"server port")
+    flags.MustParse(flags.ParseArgsE())
+    flags.MustParse(flags.ParseEnv("PROG_"))
+    fmt.Println("host",*host,"port",*port)
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+    "fmt"
+    "github.com/stevedonovan/xflag"
+)
+
+func main() {
+    xflag.PosixMode = true
+    flags := xflag.NewFlag()
+    verbose := flags.BoolP("verbose","v",false,"be verbose")
+    name := flags.StringP("name","n","dolly","name of object")
+    flags.ParseArgs()
+    // e.g. "-vn fido" sets verbose and name in one clustered argument
+    fmt.Println("verbose",*verbose,"name",*name)
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "github.com/stevedonovan/xflag"
+)
+
+func serveCmd(sub *xflag.FlagExtra) os.Error {
+    port := sub.Lookup("port")
+    fmt.Println("serving on port",port.Value.String())
+    return nil
+}
+
+func main() {
+    flags := xflag.NewFlag()
+    sub := flags.AddCommand("serve","run the server",serveCmd)
+    sub.Int("port",8080,"port to listen on")
+    flags.ParseArgs()
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "strconv"
+    "github.com/stevedonovan/xflag"
+)
+
+func main() {
+    flags := xflag.NewFlag()
+    port := flags.Int("port",8080,"port to listen on")
+    host := flags.String("#1","","device name")
+    debug := flags.Bool("debug",false,"enable debug logging")
+    flags.Required("port","#1")
+    flags.Validate("port", func(val string) os.Error {
+        n,e := strconv.Atoi(val)
+        if e != nil || n < 1 || n > 65535 {
+            return os.NewError("port must be between 1 and 65535")
+        }
+        return nil
+    })
+    // Validate on a bool flag must not stop -debug (with no attached
+    // value) from still being recognised as a bool flag
+    flags.Validate("debug", func(val string) os.Error {
+        return nil
+    })
+    if e := flags.ParseArgsE(); e != nil {
+        fmt.Println("error:",e.String())
+        os.Exit(1)
+    }
+    flags.MustParse(flags.CheckRequired())
+    fmt.Println("host",*host,"port",*port,"debug",*debug)
+}
@@ -39,6 +39,59 @@
     separated values as above:
         ports=10,20,30  #  pports := flag.IntList("ports"...
         files=*.go,makfile # pfiles := flag.OpenFileList("files"...
+
+    Flags left unset after ParseArgs/ParseConfig can be filled in from the
+    environment with ParseEnv(prefix), which looks up PREFIX_NAME by
+    default (FromEnv overrides this per flag). So the resulting precedence
+    is command line, then config file, then environment, then whatever
+    default was compiled in.
+        ports=10,20,30  #  can also come from $PROG_PORTS
+
+    ParseConfigFormat(filename,parser) reads the same kind of config file
+    in JSON or TOML, using the ConfigParser interface (xflag.JSON and
+    xflag.TOML are provided); nested tables become dotted flag names and
+    arrays feed ListValue bindings just like the comma-separated form.
+
+    AddCommand lets a FlagExtra dispatch to subcommands, each with their
+    own flags and #n positional slots:
+        var flag = xflag.NewFlag()
+        sub := flag.AddCommand("serve","run the server",serveCmd)
+        var port = sub.Int("port",8080,"port to listen on")
+        flag.ParseArgs()
+    Running "prog serve -port=9000" parses -port against sub and calls
+    serveCmd(sub); an unrecognised subcommand prints a usage tree.
+
+    Setting xflag.PosixMode = true before ParseArgs switches on GNU/POSIX
+    conventions: clustered short flags (-abc == -a -b -c), attached short
+    values (-n10), and -- to stop option processing, with #n positional
+    arguments picking up from wherever that left off. StringP/IntP/BoolP
+    register a flag under both a long and a short name.
+
+    ParseArgs/ParseConfig/ParseConfigFormat still exit on error, but are
+    now just MustParse wrapping ParseArgsE/ParseConfigE/ParseConfigFormatE,
+    which return a *FlagError (Missing, Invalid, Unknown or Unreadable) instead, for
+    programs that want to handle a bad command line themselves. Required
+    marks flags that must be set, and Validate attaches a check that runs
+    on every value a flag (or each element of a ListValue) is Set to, no
+    matter which of the above sources set it.
+
+    Since a Required flag may only be satisfiable via ParseEnv, the E
+    variants don't check this FlagExtra's own Required flags themselves
+    (a dispatched subcommand's are checked before its handler runs) - call CheckRequired once every
+    source you're using has had its turn, as ParseEnv itself does at the
+    end of its own run. ParseArgs/ParseConfig/ParseConfigFormat check it
+    right away instead, which is correct on its own but means they can't
+    be combined with a later ParseEnv call to satisfy a Required flag -
+    use ParseArgsE()/ParseConfigE() followed by ParseEnv(prefix) instead
+    (see examples/env1.go) when a flag should be satisfiable purely from
+    the environment.
+
+    GenerateCompletion(shell,w) writes a bash, zsh or fish completion
+    script assembled from the registered flags, with no external runtime
+    dependency. File-typed flags (OpenFile, CreateFile, OpenFileList) get
+    filename completion automatically; CompleteWith gives an enum-like
+    flag a fixed set of choices. Subcommands added with AddCommand are
+    descended into, so the generated script completes their flags too.
 */
 package xflag
 
@@ -51,6 +104,8 @@ import (
     "path/filepath"
     "runtime"
     "flag"
+    "json"
+    "io"
 )
 
 
@@ -62,6 +117,21 @@ type ListValue interface {
 type FlagExtra struct {
     *flag.FlagSet
     Files []*fileValue
+    envVars map[string]string
+    commands map[string]*Command
+    active *FlagExtra
+    required map[string]bool
+    validators map[string]func(string) os.Error
+    completions map[string][]string
+    setNames map[string]bool
+}
+
+// A Command is a named subcommand registered with AddCommand; it owns
+// its own flags and #n positional slots, just like a top-level FlagExtra.
+type Command struct {
+    Usage string
+    Flags *FlagExtra
+    run func(*FlagExtra) os.Error
 }
 
 func (fx *FlagExtra) quitf(format string, values... interface{}) {
@@ -71,10 +141,259 @@ func (fx *FlagExtra) quitf(format string, values... interface{}) {
     os.Exit(1)
 }
 
+// ErrorKind classifies what went wrong while parsing or validating a
+// flag; see FlagError.
+type ErrorKind int
+
+const (
+    Missing ErrorKind = iota
+    Invalid
+    Unknown
+    Unreadable
+)
+
+// FlagError is returned by the ParseArgsE/ParseConfigE family instead of
+// exiting the process: it names the offending flag, the value (if any)
+// that was tried, and what kind of failure this was.
+type FlagError struct {
+    Name string
+    Value string
+    Kind ErrorKind
+}
+
+func (e *FlagError) String() string {
+    switch e.Kind {
+    case Missing:
+        return fmt.Sprintf("missing required flag %q",e.Name)
+    case Unknown:
+        return fmt.Sprintf("unknown flag or command %q",e.Name)
+    case Unreadable:
+        return fmt.Sprintf("cannot open config file %q",e.Name)
+    default:
+        return fmt.Sprintf("invalid value %q for flag %q",e.Value,e.Name)
+    }
+}
+
+// MustParse implements the traditional exit-on-error behaviour: if e is
+// non-nil (typically the result of ParseArgsE or ParseConfigE), it's
+// printed along with this FlagExtra's usage, and the process exits.
+// ParseArgs and ParseConfig are both implemented in terms of this.
+func (fx *FlagExtra) MustParse(e os.Error) {
+    if e != nil {
+        fx.quitf("%s",e.String())
+    }
+}
+
+// Mark flags as required: CheckRequired (which ParseArgs, ParseConfig,
+// ParseConfigFormat and ParseEnv call for you) returns a Missing
+// FlagError if any of them is never set, whether on the command line, in a config file, via
+// ParseEnv, or (for OpenFile/CreateFile) left at an empty default. This
+// also covers a #n positional or vararg slot that no argument reached -
+// it's only considered set once an actual positional, config entry or
+// environment variable landed on it, not by falling back to its
+// compiled-in default.
+func (fx *FlagExtra) Required(names... string) {
+    if fx.required == nil {
+        fx.required = map[string]bool{}
+    }
+    for _, name := range names {
+        fx.required[name] = true
+    }
+}
+
+func (fx *FlagExtra) checkRequired() os.Error {
+    for name := range fx.required {
+        if ! fx.isSet(name) {
+            return &FlagError{name,"",Missing}
+        }
+    }
+    return nil
+}
+
+// CheckRequired returns a Missing FlagError for the first Required flag
+// that's still unset. Call it once, after every source you're using
+// (ParseArgsE, ParseConfigE, ParseConfigFormatE, ParseEnv) has had its
+// turn - a flag only satisfiable via ParseEnv isn't set yet the moment
+// ParseArgsE/ParseConfigE return, so they leave this to you. ParseArgs,
+// ParseConfig and ParseConfigFormat call it automatically, and ParseEnv
+// calls it at the end of its own run.
+func (fx *FlagExtra) CheckRequired() os.Error {
+    return fx.checkRequired()
+}
+
+// Register a validator for a flag: fn is called with the value the flag
+// was Set to, once parsing succeeds, and its error (if any) rejects the
+// value. For a ListValue, fn is called once per comma-separated element.
+// This is wired into the flag's Value itself, so it runs no matter how
+// the flag was set - a plain command-line "-name=value", a #n positional
+// argument, a config file, or ParseEnv.
+func (fx *FlagExtra) Validate(name string, fn func(string) os.Error) {
+    if fx.validators == nil {
+        fx.validators = map[string]func(string) os.Error{}
+    }
+    fx.validators[name] = fn
+    fx.wrapForValidation(name)
+}
+
+// validatingValue wraps a flag's Value so Set also runs the validator
+// (if any) registered for its name; installed once by wrapForValidation.
+type validatingValue struct {
+    flag.Value
+    fx *FlagExtra
+    name string
+}
+
+func (v *validatingValue) Set(val string) bool {
+    if ! v.Value.Set(val) {
+        return false
+    }
+    fn, ok := v.fx.validators[v.name]
+    return ! ok || fn(val) == nil
+}
+
+// IsBoolFlag delegates to the wrapped Value when it satisfies boolFlag,
+// so wrapping a bool flag for Validate doesn't stop the stdlib parser
+// (and isBoolFlag/posixTokens) from still treating it as one.
+func (v *validatingValue) IsBoolFlag() bool {
+    bf, ok := v.Value.(boolFlag)
+    return ok && bf.IsBoolFlag()
+}
+
+// validatingListValue is the ListValue counterpart: the validator runs
+// once per comma-separated element, matching ValueList.Set's own split.
+type validatingListValue struct {
+    validatingValue
+}
+
+func (v *validatingListValue) ListValue() {}
+
+func (v *validatingListValue) Set(val string) bool {
+    if ! v.validatingValue.Value.Set(val) {
+        return false
+    }
+    fn, ok := v.fx.validators[v.name]
+    if ! ok {
+        return true
+    }
+    for _, one := range strings.Split(val,",") {
+        if fn(one) != nil {
+            return false
+        }
+    }
+    return true
+}
+
+// wrapForValidation installs a validatingValue/validatingListValue
+// around a flag's Value (once), so every Set - from the stdlib
+// command-line parser, #n positional resolution, a config file, or
+// ParseEnv - runs through the validator registered for its name.
+func (fx *FlagExtra) wrapForValidation(name string) {
+    f := fx.Lookup(name)
+    if f == nil {
+        return
+    }
+    switch f.Value.(type) {
+    case *validatingValue, *validatingListValue:
+        return
+    }
+    if _, isList := f.Value.(ListValue); isList {
+        f.Value = &validatingListValue{validatingValue{f.Value,fx,name}}
+    } else {
+        f.Value = &validatingValue{f.Value,fx,name}
+    }
+}
+
+// unwrapValue strips a validatingValue/validatingListValue wrapper, for
+// code that needs to see the underlying concrete Value (e.g. isFileFlag).
+func unwrapValue(v flag.Value) flag.Value {
+    switch vv := v.(type) {
+    case *validatingValue:
+        return vv.Value
+    case *validatingListValue:
+        return vv.validatingValue.Value
+    }
+    return v
+}
+
+// setAndValidate sets a flag's value; if a validator was registered for
+// it, the wrapping installed by Validate runs it as part of Set itself.
+// This is used for #n positional/vararg resolution and for
+// ParseConfigFormatE, neither of which touches FlagSet's own "actual"
+// bookkeeping, so it records the flag as set itself - see isSet.
+// (ParseEnv does the same Set and markSet itself.)
+func (fx *FlagExtra) setAndValidate(f *flag.Flag, val string) os.Error {
+    if ! f.Value.Set(val) {
+        return &FlagError{f.Name,val,Invalid}
+    }
+    fx.markSet(f.Name)
+    return nil
+}
+
+// markSet records that name was given a value by a path that bypasses
+// the stdlib FlagSet.Parse (and so never lands in its own "actual" map);
+// isSet and checkRequired consult this alongside fx.Visit.
+func (fx *FlagExtra) markSet(name string) {
+    if fx.setNames == nil {
+        fx.setNames = map[string]bool{}
+    }
+    fx.setNames[name] = true
+}
+
 // Create a new FlagExtra, which has all the existing methods of flag.FlagSet,
 // plus methods for handling files more transparently.
 func NewFlag () *FlagExtra {
-    return &FlagExtra{flag.NewFlagSet(os.Args[0],flag.ExitOnError),[]*fileValue{}}
+    return newFlagExtra(os.Args[0])
+}
+
+func newFlagExtra (name string) *FlagExtra {
+    return &FlagExtra{flag.NewFlagSet(name,flag.ContinueOnError),[]*fileValue{},nil,nil,nil,nil,nil,nil,nil}
+}
+
+// Register a subcommand, cobra/urfave-cli style. The returned FlagExtra
+// is where the subcommand's own flags and #n positional slots are
+// defined; fn is invoked with that FlagExtra once ParseArgs has routed
+// a matching command-line argument to it.
+func (fx *FlagExtra) AddCommand(name, usage string, fn func(*FlagExtra) os.Error) *FlagExtra {
+    if fx.commands == nil {
+        fx.commands = map[string]*Command{}
+    }
+    child := newFlagExtra(fx.Name() + " " + name)
+    fx.commands[name] = &Command{usage,child,fn}
+    return child
+}
+
+// Print a usage tree covering this FlagExtra and all its subcommands.
+func (fx *FlagExtra) usageTree () {
+    fmt.Fprintf(os.Stderr,"usage: %s <command> [flags]\n\ncommands:\n",fx.Name())
+    for name, cmd := range fx.commands {
+        fmt.Fprintf(os.Stderr,"\n  %s - %s\n",name,cmd.Usage)
+        cmd.Flags.PrintDefaults()
+    }
+}
+
+// dispatch routes the first non-flag argument to a registered
+// subcommand, recursively parsing its remaining arguments and invoking
+// its handler; unknown subcommands print a usage tree and return an
+// Unknown FlagError.
+func (fx *FlagExtra) dispatch (args []string) os.Error {
+    if len(args) == 0 {
+        fx.usageTree()
+        return &FlagError{"<none>","",Unknown}
+    }
+    name := args[0]
+    cmd, ok := fx.commands[name]
+    if ! ok {
+        fx.usageTree()
+        return &FlagError{name,"",Unknown}
+    }
+    fx.active = cmd.Flags
+    if e := cmd.Flags.parse(args[1:],false); e != nil {
+        return e
+    }
+    if e := cmd.Flags.checkRequired(); e != nil {
+        return e
+    }
+    return cmd.run(cmd.Flags)
 }
 
 func (fx *FlagExtra) addFile (f *fileValue) {
@@ -83,10 +402,15 @@ func (fx *FlagExtra) addFile (f *fileValue) {
 
 // If you have (possibly) opened files with OpenFile or CreateFile,
 // then calling this ensures that the files are properly closed, if needed.
+// If a subcommand was dispatched, its files (and any of its own
+// subcommands') are closed too.
 func (fx *FlagExtra) Close () {
     for _, f := range fx.Files {
         f.Close()
     }
+    if fx.active != nil {
+        fx.active.Close()
+    }
 }
 
 func glob (cmdline []string) []string {
@@ -104,36 +428,81 @@ func glob (cmdline []string) []string {
 
 // Parse a set of parameters, optionally doing file glob expansion.
 func (fx *FlagExtra) Parse(cmdline []string, doGlob bool) {
+    fx.MustParse(fx.parse(cmdline,doGlob))
+    fx.MustParse(fx.CheckRequired())
+}
+
+// parse is the error-returning core shared by Parse, ParseArgsE,
+// ParseConfigE and dispatch. It does not check this FlagExtra's own
+// Required flags - at this point a flag meant to be satisfied by a later
+// ParseEnv call may still be legitimately unset - see CheckRequired. A
+// dispatched subcommand's Required flags are checked by dispatch, before
+// its handler runs.
+func (fx *FlagExtra) parse(cmdline []string, doGlob bool) os.Error {
     if doGlob {
         cmdline = glob(cmdline)
     }
-    fx.FlagSet.Parse(cmdline)
+    // the stdlib parser would otherwise print its own error+usage on
+    // failure; callers of the E family get a FlagError instead, same as
+    // every other source parse() handles
+    out := fx.Output()
+    fx.SetOutput(ioutil.Discard)
+    e := fx.FlagSet.Parse(cmdline)
+    fx.SetOutput(out)
+    if e != nil {
+        return stdlibParseError(e)
+    }
     args := fx.Args()
+    if fx.commands != nil {
+        return fx.dispatch(args)
+    }
+    var result os.Error
     fx.VisitAll(func (flag *flag.Flag) {
-        if flag.Name[0] == '#' {
-            idx,e := strconv.Atoi(flag.Name[1:])
-            if e != nil {
-                fx.quitf("bad flag index " + e.String())
-            }
-            idx --
-            var val string
-            if idx < len(args) {
-                if _,ok := flag.Value.(ListValue); ok {
-                    val = strings.Join(args[idx:],",")
-                } else {
-                    val = args[idx]
-                }
-
-            } else {
-                val = flag.DefValue
-            }
-            res := flag.Value.Set(val)
-            if ! res {
-                fx.quitf("invalid value %q for argument %d\n",val,idx+1)
-            }
+        if result != nil || flag.Name[0] != '#' {
+            return
         }
+        idx,e := strconv.Atoi(flag.Name[1:])
+        if e != nil {
+            result = &FlagError{flag.Name,"",Invalid}
+            return
+        }
+        idx --
+        if idx >= len(args) {
+            // no positional given for this #n slot: leave it unset
+            // rather than re-applying DefValue, so ParseEnv/FromEnv
+            // still gets a chance to fill it in, with the value compiled
+            // in at registration as the final fallback - see isSet
+            return
+        }
+        var val string
+        if _,ok := flag.Value.(ListValue); ok {
+            val = strings.Join(args[idx:],",")
+        } else {
+            val = args[idx]
+        }
+        result = fx.setAndValidate(flag,val)
     })
+    return result
+}
 
+// stdlibParseError turns the plain-text error FlagSet.Parse (running in
+// flag.ContinueOnError mode) returns for an unknown flag, a missing
+// value or a Set/Validate failure into a typed FlagError, by picking the
+// flag name out of the "-name" it always mentions.
+func stdlibParseError(e os.Error) os.Error {
+    msg := e.String()
+    kind := Invalid
+    if strings.Contains(msg,"not defined") {
+        kind = Unknown
+    }
+    name := ""
+    if idx := strings.LastIndex(msg," -"); idx > -1 {
+        name = msg[idx+2:]
+        if end := strings.IndexAny(name,": "); end > -1 {
+            name = name[0:end]
+        }
+    }
+    return &FlagError{name,"",kind}
 }
 
 var isWindows = runtime.GOOS == "windows"
@@ -141,16 +510,129 @@ var isWindows = runtime.GOOS == "windows"
 // Use this instead of flag.Parse();
 // It allows typed positional arguments that use #n.
 func (fx *FlagExtra) ParseArgs() {
-    fx.Parse(os.Args[1:],isWindows)
+    fx.MustParse(fx.ParseArgsE())
+    fx.MustParse(fx.CheckRequired())
+}
+
+// Like ParseArgs, but returns a *FlagError instead of exiting, for
+// callers that want to handle Missing/Invalid/Unknown flags themselves.
+// When a subcommand is dispatched, an error from its handler is
+// returned as is.
+func (fx *FlagExtra) ParseArgsE() os.Error {
+    if PosixMode {
+        return fx.parse(fx.posixTokens(os.Args[1:]),isWindows)
+    }
+    return fx.parse(os.Args[1:],isWindows)
+}
+
+// When true, ParseArgs rewrites its arguments pflag/GNU-style before
+// parsing; see ParsePosix.
+var PosixMode = false
+
+// boolFlag mirrors the optional interface the flag package's own bool
+// values satisfy (pflag uses the same trick), so a short option's
+// boolness can be detected from its actual type rather than guessed.
+type boolFlag interface {
+    IsBoolFlag() bool
+}
+
+func (fx *FlagExtra) isBoolFlag(name string) bool {
+    f := fx.Lookup(name)
+    if f == nil {
+        return false
+    }
+    bf, ok := f.Value.(boolFlag)
+    return ok && bf.IsBoolFlag()
+}
+
+// Rewrite posix/GNU-style arguments - clustered short flags (-abc ==
+// -a -b -c), a short flag with its value attached (-n10), and -- to
+// stop option processing - into the single-letter, one-option-per-token
+// form the stdlib flag package already understands (which also covers
+// --name=value, --name value and -- on its own). Long and short names
+// sharing a value, as registered by StringP and friends, just work,
+// since their two flag.Values both write to the same variable.
+func (fx *FlagExtra) posixTokens(args []string) []string {
+    out := []string{}
+    for i := 0; i < len(args); i++ {
+        a := args[i]
+        if a == "--" {
+            out = append(out,args[i:]...)
+            break
+        }
+        if len(a) > 2 && a[0] == '-' && a[1] != '-' {
+            rest := a[1:]
+            for len(rest) > 0 {
+                short := rest[0:1]
+                rest = rest[1:]
+                hadEquals := false
+                if len(rest) > 0 && rest[0] == '=' {
+                    // -n=10: the attached value already has its own "="
+                    rest = rest[1:]
+                    hadEquals = true
+                }
+                // an explicit "=value" is honoured even for a bool short
+                // (-v=false), so the stdlib parser - not this clustering
+                // loop - decides what "false" means; a value attached
+                // without "=" only applies to non-bool shorts, since
+                // that's ambiguous with further clustered bool shorts
+                if (hadEquals || ! fx.isBoolFlag(short)) && len(rest) > 0 {
+                    out = append(out,"-"+short+"="+rest)
+                    rest = ""
+                } else {
+                    out = append(out,"-"+short)
+                }
+            }
+            continue
+        }
+        out = append(out,a)
+    }
+    return out
+}
+
+// Like Parse, but first rewrites posix/GNU-style arguments - clustered
+// short flags, -n10, -- - before the usual flag.FlagSet parsing and #n
+// positional resolution take over. ParseArgs does the same rewriting
+// automatically when PosixMode is true.
+func (fx *FlagExtra) ParsePosix(cmdline []string, doGlob bool) {
+    fx.Parse(fx.posixTokens(cmdline),doGlob)
+}
+
+// Register a string flag under both a long and a short name (posix
+// style -o/--output); both point at the same value.
+func (fx *FlagExtra) StringP(name, short, def, usage string) *string {
+    p := fx.String(name,def,usage)
+    fx.StringVar(p,short,def,usage)
+    return p
+}
+
+// Register an int flag under both a long and a short name.
+func (fx *FlagExtra) IntP(name, short string, def int, usage string) *int {
+    p := fx.Int(name,def,usage)
+    fx.IntVar(p,short,def,usage)
+    return p
+}
+
+// Register a bool flag under both a long and a short name.
+func (fx *FlagExtra) BoolP(name, short string, def bool, usage string) *bool {
+    p := fx.Bool(name,def,usage)
+    fx.BoolVar(p,short,def,usage)
+    return p
 }
 
 // Program arguments may be read from a named configuration file. This
 // is similar to the command-line format, except that initial hyphen
 // is not used and lines may end with a # comment.
 func (fx *FlagExtra) ParseConfig(filename string) {
+    fx.MustParse(fx.ParseConfigE(filename))
+    fx.MustParse(fx.CheckRequired())
+}
+
+// Like ParseConfig, but returns a *FlagError instead of exiting.
+func (fx *FlagExtra) ParseConfigE(filename string) os.Error {
     bytes, e := ioutil.ReadFile(filename)
     if e != nil {
-        fx.quitf("cannot open config file %q",filename)
+        return &FlagError{filename,"",Unreadable}
     }
     // necessary Windows hack
     contents := strings.Replace(string(bytes),"\r\n","\n",-1)
@@ -171,7 +653,211 @@ func (fx *FlagExtra) ParseConfig(filename string) {
             out = append(out,line)
         }
     }
-    fx.Parse(out,true)
+    return fx.parse(out,true)
+}
+
+// A ConfigParser decodes a config file's contents into a tree of values,
+// for use with ParseConfigFormat. Maps represent nested tables/objects,
+// and anything else is stored as-is (strings, numbers, []interface{}, ...).
+type ConfigParser interface {
+    Parse(data []byte) (map[string]interface{}, os.Error)
+}
+
+type jsonConfigParser struct{}
+
+func (jsonConfigParser) Parse(data []byte) (map[string]interface{}, os.Error) {
+    var tree map[string]interface{}
+    if e := json.Unmarshal(data,&tree); e != nil {
+        return nil, e
+    }
+    return tree, nil
+}
+
+// Decodes JSON config files for use with ParseConfigFormat.
+var JSON ConfigParser = jsonConfigParser{}
+
+type tomlConfigParser struct{}
+
+func (tomlConfigParser) Parse(data []byte) (map[string]interface{}, os.Error) {
+    tree := map[string]interface{}{}
+    contents := strings.Replace(string(data),"\r\n","\n",-1)
+    section := ""
+    for _,line := range strings.Split(contents,"\n") {
+        line = strings.TrimSpace(line)
+        if len(line) == 0 || line[0] == '#' {
+            continue
+        }
+        if line[0] == '[' {
+            section = strings.TrimSpace(strings.Trim(line,"[]"))
+            continue
+        }
+        idx := strings.Index(line,"=")
+        if idx == -1 {
+            continue
+        }
+        key := strings.TrimSpace(line[0:idx])
+        if section != "" {
+            key = section + "." + key
+        }
+        tree[key] = tomlValue(strings.TrimSpace(line[idx+1:]))
+    }
+    return tree, nil
+}
+
+func tomlValue(val string) interface{} {
+    if strings.HasPrefix(val,"[") && strings.HasSuffix(val,"]") {
+        items := strings.Split(val[1:len(val)-1],",")
+        list := make([]interface{},len(items))
+        for i,item := range items {
+            list[i] = tomlScalar(strings.TrimSpace(item))
+        }
+        return list
+    }
+    return tomlScalar(val)
+}
+
+func tomlScalar(val string) interface{} {
+    if len(val) >= 2 && val[0] == '"' && val[len(val)-1] == '"' {
+        return val[1:len(val)-1]
+    }
+    return val
+}
+
+// Decodes a restricted subset of TOML (tables, strings, bare values and
+// flat arrays) for use with ParseConfigFormat; enough to mirror the
+// key=value files ParseConfig already understands, with [section] tables.
+var TOML ConfigParser = tomlConfigParser{}
+
+// flatten walks a decoded config tree, turning nested tables into dotted
+// names (server.port) and arrays into comma-separated values, matching
+// the convention ValueList.Set already expects.
+func flatten(prefix string, tree map[string]interface{}, out map[string]string) {
+    for key, val := range tree {
+        name := key
+        if prefix != "" {
+            name = prefix + "." + key
+        }
+        switch v := val.(type) {
+        case map[string]interface{}:
+            flatten(name,v,out)
+        case []interface{}:
+            parts := make([]string,len(v))
+            for i,item := range v {
+                parts[i] = fmt.Sprintf("%v",item)
+            }
+            out[name] = strings.Join(parts,",")
+        default:
+            out[name] = fmt.Sprintf("%v",v)
+        }
+    }
+}
+
+// Parse a config file in an arbitrary format (JSON, TOML, or a custom
+// ConfigParser), walking the decoded tree and setting registered flags
+// by name. Nested tables map to dotted flag names (server.port -> flag
+// "server.port"), and arrays populate ListValue bindings such as
+// OpenFileList, IntList and StringList. Unknown keys are ignored, since
+// config files commonly carry settings this program doesn't use.
+func (fx *FlagExtra) ParseConfigFormat(filename string, parser ConfigParser) {
+    fx.MustParse(fx.ParseConfigFormatE(filename,parser))
+    fx.MustParse(fx.CheckRequired())
+}
+
+// Like ParseConfigFormat, but returns a *FlagError instead of exiting.
+func (fx *FlagExtra) ParseConfigFormatE(filename string, parser ConfigParser) os.Error {
+    bytes, e := ioutil.ReadFile(filename)
+    if e != nil {
+        return &FlagError{filename,"",Unreadable}
+    }
+    tree, e := parser.Parse(bytes)
+    if e != nil {
+        return &FlagError{filename,e.String(),Invalid}
+    }
+    flat := map[string]string{}
+    flatten("",tree,flat)
+    for name, val := range flat {
+        f := fx.Lookup(name)
+        if f == nil {
+            continue
+        }
+        if e := fx.setAndValidate(f,val); e != nil {
+            return e
+        }
+    }
+    return nil
+}
+
+// true if this flag was actually set, either on the command line (per
+// the stdlib FlagSet's own bookkeeping, which also covers ParseConfig's
+// key=value files) or via #n positional/vararg resolution,
+// ParseConfigFormat, or ParseEnv (tracked separately in
+// setNames, since none of those go through FlagSet.Parse - see
+// setAndValidate and markSet).
+func (fx *FlagExtra) isSet(name string) bool {
+    if fx.setNames[name] {
+        return true
+    }
+    found := false
+    fx.Visit(func (f *flag.Flag) {
+        if f.Name == name {
+            found = true
+        }
+    })
+    return found
+}
+
+// Give a flag an explicit environment variable name, overriding the
+// default prefix+NAME mapping used by ParseEnv. Useful for #n positional
+// and vararg flags, which have no sensible default mapping.
+func (fx *FlagExtra) FromEnv(name, envVar string) {
+    if fx.envVars == nil {
+        fx.envVars = map[string]string{}
+    }
+    fx.envVars[name] = envVar
+}
+
+func envName(prefix, name string) string {
+    return prefix + strings.ToUpper(strings.Replace(name,"-","_",-1))
+}
+
+// Fill in any flags not already set on the command line or in a config
+// file from environment variables. By default a flag called "name" is
+// looked up as prefix+NAME (uppercased, "-" becoming "_"); use FromEnv
+// to give a flag an explicit variable name instead. Call this after
+// ParseArgsE and/or ParseConfigE (not the exit-on-error ParseArgs/
+// ParseConfig, which already check Required themselves), so that the
+// precedence ends up being CLI args > config file > environment >
+// compiled-in default; this also runs CheckRequired at the end, so a
+// flag meant to be satisfiable purely via the environment still gets
+// checked. Returns an Invalid FlagError if an environment value is
+// rejected by the flag, or a Missing one from CheckRequired.
+func (fx *FlagExtra) ParseEnv(prefix string) os.Error {
+    var result os.Error
+    fx.VisitAll(func (f *flag.Flag) {
+        if result != nil || fx.isSet(f.Name) {
+            return
+        }
+        envVar, ok := fx.envVars[f.Name]
+        if ! ok {
+            if f.Name[0] == '#' {
+                return // positional/vararg flags need an explicit FromEnv mapping
+            }
+            envVar = envName(prefix,f.Name)
+        }
+        val := os.Getenv(envVar)
+        if len(val) == 0 {
+            return
+        }
+        if ! f.Value.Set(val) {
+            result = &FlagError{f.Name,val,Invalid}
+            return
+        }
+        fx.markSet(f.Name)
+    })
+    if result != nil {
+        return result
+    }
+    return fx.checkRequired()
 }
 
 type fileValue struct {
@@ -226,17 +912,18 @@ func (fx *FlagExtra) newFileValue(name string, in bool) *fileValue {
     return file
 }
 
-// Opens a file for reading. The default value may be empty (meaning that
-// this is a required parameter), a valid file, or "stdin" meaning open
-// standard input.
+// Opens a file for reading. The default value may be empty (in which
+// case *os.File stays nil until the flag is actually given a value; use
+// Required to reject that instead), a valid file, or "stdin" meaning
+// open standard input.
 func (fx *FlagExtra) OpenFile(name, def, usage string) **os.File {
     file := fx.newFileValue(def,true)
     fx.Var(file,name,usage)
     return &file.f
 }
 
-// Opens a file for writing. An empty default string means a required file
-// parameter, and "stdout" means open standard output.
+// Opens a file for writing. An empty default string leaves *os.File nil
+// until set (see Required), and "stdout" means open standard output.
 func (fx *FlagExtra) CreateFile(name, def, usage string) **os.File {
     file := fx.newFileValue(def,false)
     fx.Var(file,name,usage)
@@ -341,3 +1028,134 @@ func (fx *FlagExtra) StringList(name, def, usage string) *[]string {
     return &slist.strings
 }
 
+// Register a fixed set of completion values for a flag, for use by
+// GenerateCompletion; for enum-like flags such as -color red|green|blue.
+func (fx *FlagExtra) CompleteWith(name string, choices... string) {
+    if fx.completions == nil {
+        fx.completions = map[string][]string{}
+    }
+    fx.completions[name] = choices
+}
+
+func (fx *FlagExtra) isFileFlag(f *flag.Flag) bool {
+    switch unwrapValue(f.Value).(type) {
+    case *fileValue, *filesValue:
+        return true
+    }
+    return false
+}
+
+func sanitizeName(name string) string {
+    return strings.Replace(name," ","_",-1)
+}
+
+// Generate a completion script for bash, zsh or fish, assembled from the
+// in-memory flag registry: every registered flag and its usage string,
+// filename completion for OpenFile/CreateFile/OpenFileList flags,
+// CompleteWith choices for enum-like flags, and - recursively - every
+// subcommand added with AddCommand.
+func (fx *FlagExtra) GenerateCompletion(shell string, w io.Writer) os.Error {
+    switch shell {
+    case "bash":
+        fn := sanitizeName(fx.Name())
+        fx.writeBashCompletion(w,[]string{fn})
+        fmt.Fprintf(w,"complete -F _%s %s\n",fn,fx.Name())
+    case "zsh":
+        fmt.Fprintf(w,"#compdef %s\n\n",fx.Name())
+        fx.writeZshCompletion(w,fx.Name())
+    case "fish":
+        fx.writeFishCompletion(w,fx.Name(),[]string{})
+    default:
+        return &FlagError{shell,"",Unknown}
+    }
+    return nil
+}
+
+// writeBashCompletion emits one "_name_sub_sub" function per FlagExtra
+// in the command tree, each dispatching to the next level by position
+// in COMP_WORDS, following cobra's descend-into-subcommands approach.
+func (fx *FlagExtra) writeBashCompletion(w io.Writer, path []string) {
+    fmt.Fprintf(w,"_%s() {\n    local cur=\"${COMP_WORDS[COMP_CWORD]}\" prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n",strings.Join(path,"_"))
+    opts := []string{}
+    fx.VisitAll(func (f *flag.Flag) {
+        if f.Name[0] == '#' {
+            return
+        }
+        opts = append(opts,"-"+f.Name)
+        if fx.isFileFlag(f) {
+            fmt.Fprintf(w,"    [ \"$prev\" = \"-%s\" ] && { COMPREPLY=( $(compgen -f -- \"$cur\") ); return; }\n",f.Name)
+        } else if choices, ok := fx.completions[f.Name]; ok {
+            fmt.Fprintf(w,"    [ \"$prev\" = \"-%s\" ] && { COMPREPLY=( $(compgen -W %q -- \"$cur\") ); return; }\n",f.Name,strings.Join(choices," "))
+        }
+    })
+    for name := range fx.commands {
+        opts = append(opts,name)
+    }
+    for name := range fx.commands {
+        sub := append(append([]string{},path...),name)
+        fmt.Fprintf(w,"    [ \"${COMP_WORDS[%d]}\" = %q ] && { _%s; return; }\n",len(path),name,strings.Join(sub,"_"))
+    }
+    fmt.Fprintf(w,"    COMPREPLY=( $(compgen -W %q -- \"$cur\") )\n}\n",strings.Join(opts," "))
+    for name, cmd := range fx.commands {
+        cmd.Flags.writeBashCompletion(w,append(append([]string{},path...),name))
+    }
+}
+
+// writeZshCompletion emits one "_name" function per FlagExtra in the
+// command tree, using _arguments for flags and listing subcommands as
+// further completions; each function hands off to a subcommand's own
+// function once that subcommand's name is in $words.
+func (fx *FlagExtra) writeZshCompletion(w io.Writer, prog string) {
+    fmt.Fprintf(w,"_%s() {\n    local -a opts\n    opts=(\n",sanitizeName(prog))
+    fx.VisitAll(func (f *flag.Flag) {
+        if f.Name[0] == '#' {
+            return
+        }
+        hint := ""
+        if fx.isFileFlag(f) {
+            hint = ":file:_files"
+        } else if choices, ok := fx.completions[f.Name]; ok {
+            hint = fmt.Sprintf(":choice:(%s)",strings.Join(choices," "))
+        }
+        fmt.Fprintf(w,"        '-%s[%s]%s'\n",f.Name,f.Usage,hint)
+    })
+    for name, cmd := range fx.commands {
+        fmt.Fprintf(w,"        '%s:%s'\n",name,cmd.Usage)
+    }
+    fmt.Fprintf(w,"    )\n")
+    depth := len(strings.Split(prog," ")) + 1
+    for name := range fx.commands {
+        fmt.Fprintf(w,"    [[ ${words[%d]} == %q ]] && { _%s; return }\n",depth,name,sanitizeName(prog+" "+name))
+    }
+    fmt.Fprintf(w,"    _arguments $opts\n}\n")
+    for name, cmd := range fx.commands {
+        cmd.Flags.writeZshCompletion(w,prog+" "+name)
+    }
+}
+
+// writeFishCompletion emits "complete -c" lines for this FlagExtra and,
+// recursively, every subcommand, each guarded by
+// __fish_seen_subcommand_from so fish only offers them at the right depth.
+func (fx *FlagExtra) writeFishCompletion(w io.Writer, prog string, path []string) {
+    cond := "__fish_use_subcommand"
+    if len(path) > 0 {
+        cond = "__fish_seen_subcommand_from " + strings.Join(path," ")
+    }
+    fx.VisitAll(func (f *flag.Flag) {
+        if f.Name[0] == '#' {
+            return
+        }
+        extra := ""
+        if fx.isFileFlag(f) {
+            extra = " -r -F"
+        } else if choices, ok := fx.completions[f.Name]; ok {
+            extra = fmt.Sprintf(" -r -a %q",strings.Join(choices," "))
+        }
+        fmt.Fprintf(w,"complete -c %s -n %q -l %s -d %q%s\n",prog,cond,f.Name,f.Usage,extra)
+    })
+    for name, cmd := range fx.commands {
+        fmt.Fprintf(w,"complete -c %s -n %q -a %s -d %q\n",prog,cond,name,cmd.Usage)
+        cmd.Flags.writeFishCompletion(w,prog,append(path,name))
+    }
+}
+